@@ -0,0 +1,449 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+// N.B.: While we could use textproto to get the canonical mime header, we
+// don't want to as it changes the "-" into "_", e.g. X-Vault-Token into
+// X_Vault_Token
+const (
+	auditedRequestHeadersEntry  = "audited-request-headers"
+	auditedResponseHeadersEntry = "audited-response-headers"
+
+	// legacyAuditedHeadersEntry is where the config lived before response
+	// headers got their own namespace. On first load, a value found here is
+	// treated as the request header map and rewritten under
+	// auditedRequestHeadersEntry.
+	legacyAuditedHeadersEntry = "audited-headers"
+)
+
+// headerDirection selects which of AuditedHeadersConfig's two namespaces an
+// add/remove operates against.
+type headerDirection int
+
+const (
+	headerDirectionRequest headerDirection = iota
+	headerDirectionResponse
+)
+
+// storageKey returns the barrier entry this direction is persisted under.
+func (d headerDirection) storageKey() string {
+	if d == headerDirectionResponse {
+		return auditedResponseHeadersEntry
+	}
+	return auditedRequestHeadersEntry
+}
+
+// Redaction modes supported by auditedHeaderSettings.Mode. "truncate:N" and
+// "mask:keepN" are parameterized and matched by prefix rather than appearing
+// here directly.
+const (
+	headerModePassthrough = "passthrough"
+	headerModeHMAC        = "hmac"
+	headerModeRedact      = "redact"
+
+	headerModeTruncatePrefix = "truncate:"
+	headerModeMaskPrefix     = "mask:keep"
+)
+
+// redactedValue is emitted, as the sole element of the result slice, for any
+// header using headerModeRedact.
+const redactedValue = "<redacted>"
+
+// auditedHeadersBarrierView is the subset of *BarrierView's API that
+// AuditedHeadersConfig depends on, extracted so tests can substitute a fake
+// that blocks or fails under a cancelled context instead of exercising a
+// real barrier.
+type auditedHeadersBarrierView interface {
+	Put(ctx context.Context, entry *logical.StorageEntry) error
+	Get(ctx context.Context, key string) (*logical.StorageEntry, error)
+}
+
+// AuditedHeadersConfig is used by the Audit Broker to write only the
+// specified headers out to the audit logs. It tracks request headers and
+// response headers as separate namespaces, each persisted under its own
+// barrier entry. Entries are either an exact header name or, when IsPrefix
+// is set, a prefix (e.g. "X-Request-") that is matched against the name of
+// every incoming header.
+type AuditedHeadersConfig struct {
+	RequestHeaders  map[string]*auditedHeaderSettings
+	ResponseHeaders map[string]*auditedHeaderSettings
+	sync.RWMutex
+	view auditedHeadersBarrierView
+
+	// requestPrefixes and responsePrefixes are compiled views of the
+	// entries in RequestHeaders/ResponseHeaders that have IsPrefix set.
+	// They are rebuilt by add/remove so that ApplyConfig/ApplyResponseConfig
+	// can walk them directly instead of re-deriving them on every request.
+	requestPrefixes  []*auditedHeaderSettings
+	responsePrefixes []*auditedHeaderSettings
+}
+
+// auditedHeaderSettings is used to store the settings for a header
+type auditedHeaderSettings struct {
+	Pattern  string
+	Mode     string
+	IsPrefix bool
+}
+
+// legacyAuditedHeaderSettings mirrors the on-disk shape of
+// auditedHeaderSettings from before Mode replaced the HMAC bool. It is only
+// used to detect and translate that older format during decode.
+type legacyAuditedHeaderSettings struct {
+	Pattern  string
+	Mode     string
+	IsPrefix bool
+	HMAC     *bool
+}
+
+// UnmarshalJSON allows auditedHeaderSettings to decode both the current
+// Mode-based format and the legacy format that stored a bare HMAC bool. When
+// both are absent the zero value defaults to passthrough.
+func (s *auditedHeaderSettings) UnmarshalJSON(data []byte) error {
+	var raw legacyAuditedHeaderSettings
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	s.Pattern = raw.Pattern
+	s.IsPrefix = raw.IsPrefix
+	s.Mode = raw.Mode
+
+	if s.Mode == "" && raw.HMAC != nil {
+		if *raw.HMAC {
+			s.Mode = headerModeHMAC
+		} else {
+			s.Mode = headerModePassthrough
+		}
+	}
+
+	if s.Mode == "" {
+		s.Mode = headerModePassthrough
+	}
+
+	return nil
+}
+
+// AddHeaderOptions controls how a header added via add is handled by
+// ApplyConfig.
+type AddHeaderOptions struct {
+	// Mode is one of passthrough, hmac, redact, "truncate:N" or
+	// "mask:keepN". Defaults to passthrough when empty.
+	Mode string
+
+	// IsPrefix marks header as a prefix (e.g. "X-Request-") to be matched
+	// against the name of every incoming header, rather than an exact name.
+	IsPrefix bool
+}
+
+// add adds or overwrites a header in the config for the given direction and
+// updates the underlying view. header is either an exact header name or,
+// when opts.IsPrefix is true, a prefix matched against the canonical name
+// of incoming headers. The in-memory map is only mutated after the storage
+// write succeeds, so a cancelled ctx leaves it untouched.
+func (a *AuditedHeadersConfig) add(ctx context.Context, header string, opts AddHeaderOptions, direction headerDirection) error {
+	if header == "" {
+		return fmt.Errorf("header value cannot be empty")
+	}
+
+	mode := opts.Mode
+	if mode == "" {
+		mode = headerModePassthrough
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	existing := a.headersLocked(direction)
+	headers := make(map[string]*auditedHeaderSettings, len(existing)+1)
+	for k, v := range existing {
+		headers[k] = v
+	}
+	headers[header] = &auditedHeaderSettings{
+		Pattern:  header,
+		Mode:     mode,
+		IsPrefix: opts.IsPrefix,
+	}
+
+	entry, err := logical.StorageEntryJSON(direction.storageKey(), headers)
+	if err != nil {
+		return fmt.Errorf("failed to persist audited headers config: %v", err)
+	}
+
+	if err := a.view.Put(ctx, entry); err != nil {
+		return fmt.Errorf("failed to persist audited headers config: %v", err)
+	}
+
+	a.setHeadersLocked(direction, headers)
+	a.rebuildPrefixesLocked(direction)
+
+	return nil
+}
+
+// remove removes a header from the config for the given direction and
+// updates the underlying view. The in-memory map is only mutated after the
+// storage write succeeds, so a cancelled ctx leaves it untouched.
+func (a *AuditedHeadersConfig) remove(ctx context.Context, header string, direction headerDirection) error {
+	if header == "" {
+		return fmt.Errorf("header value cannot be empty")
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	existing := a.headersLocked(direction)
+	if _, ok := existing[header]; !ok {
+		return nil
+	}
+
+	headers := make(map[string]*auditedHeaderSettings, len(existing))
+	for k, v := range existing {
+		if k == header {
+			continue
+		}
+		headers[k] = v
+	}
+
+	entry, err := logical.StorageEntryJSON(direction.storageKey(), headers)
+	if err != nil {
+		return fmt.Errorf("failed to persist audited headers config: %v", err)
+	}
+
+	if err := a.view.Put(ctx, entry); err != nil {
+		return fmt.Errorf("failed to persist audited headers config: %v", err)
+	}
+
+	a.setHeadersLocked(direction, headers)
+	a.rebuildPrefixesLocked(direction)
+
+	return nil
+}
+
+// headersLocked returns the header map for direction. Callers must hold
+// at least the read lock.
+func (a *AuditedHeadersConfig) headersLocked(direction headerDirection) map[string]*auditedHeaderSettings {
+	if direction == headerDirectionResponse {
+		return a.ResponseHeaders
+	}
+	return a.RequestHeaders
+}
+
+// setHeadersLocked assigns the header map for direction. Callers must hold
+// the write lock.
+func (a *AuditedHeadersConfig) setHeadersLocked(direction headerDirection, headers map[string]*auditedHeaderSettings) {
+	if direction == headerDirectionResponse {
+		a.ResponseHeaders = headers
+	} else {
+		a.RequestHeaders = headers
+	}
+}
+
+// rebuildPrefixesLocked recomputes the compiled prefix list for direction
+// from its header map, sorted longest-pattern-first so that
+// applyHeaderSettings' first match is always the most specific one
+// regardless of the map iteration order it was built from. Callers must
+// hold the write lock.
+func (a *AuditedHeadersConfig) rebuildPrefixesLocked(direction headerDirection) {
+	headers := a.headersLocked(direction)
+	prefixes := make([]*auditedHeaderSettings, 0, len(headers))
+	for _, settings := range headers {
+		if settings.IsPrefix {
+			prefixes = append(prefixes, settings)
+		}
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		return len(prefixes[i].Pattern) > len(prefixes[j].Pattern)
+	})
+	if direction == headerDirectionResponse {
+		a.responsePrefixes = prefixes
+	} else {
+		a.requestPrefixes = prefixes
+	}
+}
+
+// ApplyConfig returns a map of request header names to their values, as
+// dictated by the config. Exact matches in RequestHeaders take priority
+// over prefix matches. The audit broker's request-formatting path is the
+// intended caller of this method.
+func (a *AuditedHeadersConfig) ApplyConfig(headers map[string][]string, hashFunc func(string) string) map[string][]string {
+	a.RLock()
+	defer a.RUnlock()
+
+	return applyHeaderSettings(headers, a.RequestHeaders, a.requestPrefixes, hashFunc)
+}
+
+// ApplyResponseConfig returns a map of response header names to their
+// values, as dictated by the config. Exact matches in ResponseHeaders take
+// priority over prefix matches. Callers that format the response side of an
+// audit entry (e.g. the audit broker, alongside its ApplyConfig call on the
+// request side) should call this so response headers such as Set-Cookie or
+// X-Vault-Index are filtered the same way request headers are; audit_broker.go
+// itself is not part of this vendored package subset, so that wiring isn't
+// present here.
+func (a *AuditedHeadersConfig) ApplyResponseConfig(headers map[string][]string, hashFunc func(string) string) map[string][]string {
+	a.RLock()
+	defer a.RUnlock()
+
+	return applyHeaderSettings(headers, a.ResponseHeaders, a.responsePrefixes, hashFunc)
+}
+
+// applyHeaderSettings is the shared implementation behind ApplyConfig and
+// ApplyResponseConfig. prefixes is expected to be sorted longest-pattern-
+// first (see rebuildPrefixesLocked) so that among overlapping prefix
+// entries the most specific one always wins, deterministically.
+func applyHeaderSettings(headers map[string][]string, settingsMap map[string]*auditedHeaderSettings, prefixes []*auditedHeaderSettings, hashFunc func(string) string) map[string][]string {
+	result := make(map[string][]string, len(settingsMap))
+
+	for header, hVals := range headers {
+		settings, ok := settingsMap[header]
+		if !ok {
+			for _, p := range prefixes {
+				if strings.HasPrefix(header, p.Pattern) {
+					settings = p
+					ok = true
+					break
+				}
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		result[header] = applyHeaderMode(hVals, settings.Mode, hashFunc)
+	}
+
+	return result
+}
+
+// applyHeaderMode redacts, truncates, masks or hashes vals per mode, without
+// modifying the slice or strings passed in.
+func applyHeaderMode(vals []string, mode string, hashFunc func(string) string) []string {
+	if mode == headerModeRedact {
+		return []string{redactedValue}
+	}
+
+	out := make([]string, len(vals))
+	copy(out, vals)
+
+	switch {
+	case mode == headerModeHMAC:
+		for i, el := range out {
+			out[i] = hashFunc(el)
+		}
+	case strings.HasPrefix(mode, headerModeTruncatePrefix):
+		n, err := strconv.Atoi(strings.TrimPrefix(mode, headerModeTruncatePrefix))
+		if err != nil || n < 0 {
+			break
+		}
+		for i, el := range out {
+			if len(el) > n {
+				out[i] = el[:n] + "..."
+			}
+		}
+	case strings.HasPrefix(mode, headerModeMaskPrefix):
+		n, err := strconv.Atoi(strings.TrimPrefix(mode, headerModeMaskPrefix))
+		if err != nil || n < 0 {
+			break
+		}
+		for i, el := range out {
+			if n >= len(el) {
+				continue
+			}
+			out[i] = strings.Repeat("*", len(el)-n) + el[len(el)-n:]
+		}
+	}
+
+	return out
+}
+
+// loadAuditedHeadersConfig loads the request and response header config
+// from view. If no request-headers entry exists yet, it falls back to the
+// legacy pre-response-header-support entry, treats that value as the
+// request header map, and rewrites it under the new key so later loads skip
+// the fallback.
+func loadAuditedHeadersConfig(ctx context.Context, view auditedHeadersBarrierView) (*AuditedHeadersConfig, error) {
+	a := &AuditedHeadersConfig{view: view}
+
+	reqEntryExists, err := a.invalidate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !reqEntryExists {
+		legacyEntry, err := view.Get(ctx, legacyAuditedHeadersEntry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read legacy audited headers config: %v", err)
+		}
+		if legacyEntry != nil {
+			legacyHeaders := make(map[string]*auditedHeaderSettings)
+			if err := legacyEntry.DecodeJSON(&legacyHeaders); err != nil {
+				return nil, fmt.Errorf("failed to decode legacy audited headers config: %v", err)
+			}
+
+			entry, err := logical.StorageEntryJSON(auditedRequestHeadersEntry, legacyHeaders)
+			if err != nil {
+				return nil, fmt.Errorf("failed to persist migrated audited headers config: %v", err)
+			}
+			if err := view.Put(ctx, entry); err != nil {
+				return nil, fmt.Errorf("failed to persist migrated audited headers config: %v", err)
+			}
+
+			a.RequestHeaders = legacyHeaders
+			a.rebuildPrefixesLocked(headerDirectionRequest)
+		}
+	}
+
+	return a, nil
+}
+
+// invalidate reloads RequestHeaders and ResponseHeaders from storage. Like
+// add/remove, the in-memory maps are only swapped in after both reads
+// succeed, so a cancelled ctx (or a storage error) leaves the existing
+// in-memory view untouched. It reports whether the request-headers entry
+// itself was present, so loadAuditedHeadersConfig can tell "key never
+// written" (fall back to the legacy key) apart from "key written with an
+// intentionally empty map" (do not resurrect the legacy value).
+func (a *AuditedHeadersConfig) invalidate(ctx context.Context) (reqEntryExists bool, err error) {
+	reqEntry, err := a.view.Get(ctx, auditedRequestHeadersEntry)
+	if err != nil {
+		return false, fmt.Errorf("failed to read audited request headers config: %v", err)
+	}
+
+	requestHeaders := make(map[string]*auditedHeaderSettings)
+	if reqEntry != nil {
+		if err := reqEntry.DecodeJSON(&requestHeaders); err != nil {
+			return false, fmt.Errorf("failed to decode audited request headers config: %v", err)
+		}
+	}
+
+	respEntry, err := a.view.Get(ctx, auditedResponseHeadersEntry)
+	if err != nil {
+		return false, fmt.Errorf("failed to read audited response headers config: %v", err)
+	}
+
+	responseHeaders := make(map[string]*auditedHeaderSettings)
+	if respEntry != nil {
+		if err := respEntry.DecodeJSON(&responseHeaders); err != nil {
+			return false, fmt.Errorf("failed to decode audited response headers config: %v", err)
+		}
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	a.RequestHeaders = requestHeaders
+	a.ResponseHeaders = responseHeaders
+	a.rebuildPrefixesLocked(headerDirectionRequest)
+	a.rebuildPrefixesLocked(headerDirectionResponse)
+
+	return reqEntry != nil, nil
+}
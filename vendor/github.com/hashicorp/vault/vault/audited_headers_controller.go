@@ -0,0 +1,261 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+// HeaderConfigSource supplies the desired audited-header configuration for
+// one direction (request or response) of an AuditedHeadersConfig from an
+// external store, such as a file on disk or a logical.Storage path. Fetch
+// is called on every sync tick, so implementations should be cheap to call
+// repeatedly.
+type HeaderConfigSource interface {
+	Fetch(ctx context.Context) (map[string]*auditedHeaderSettings, error)
+}
+
+// HeadersChanged describes, by header/pattern name, what a sync of a
+// HeaderConfigSource changed in an AuditedHeadersConfig.
+type HeadersChanged struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+func (h HeadersChanged) isEmpty() bool {
+	return len(h.Added) == 0 && len(h.Removed) == 0 && len(h.Changed) == 0
+}
+
+// AuditedHeadersController periodically reconciles one direction of an
+// AuditedHeadersConfig against a HeaderConfigSource, driving it through the
+// config's own add/remove so the barrier view stays canonical. This mirrors
+// the Kubernetes requestheader controller pattern, where the allowed header
+// list is sourced from a ConfigMap and hot-reloaded without a restart.
+type AuditedHeadersController struct {
+	config    *AuditedHeadersConfig
+	source    HeaderConfigSource
+	direction headerDirection
+	interval  time.Duration
+
+	events chan HeadersChanged
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+	doneCh   chan struct{}
+}
+
+// NewAuditedHeadersController creates a controller that, once Run is
+// called, syncs config's direction namespace from source every interval.
+// interval must be positive: time.NewTicker, which Run relies on, panics
+// on a zero or negative duration.
+func NewAuditedHeadersController(config *AuditedHeadersConfig, source HeaderConfigSource, direction headerDirection, interval time.Duration) (*AuditedHeadersController, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("sync interval must be positive, got %s", interval)
+	}
+
+	return &AuditedHeadersController{
+		config:    config,
+		source:    source,
+		direction: direction,
+		interval:  interval,
+		events:    make(chan HeadersChanged, 1),
+		stopCh:    make(chan struct{}),
+		doneCh:    make(chan struct{}),
+	}, nil
+}
+
+// Events returns the channel HeadersChanged notifications are emitted on,
+// so audit backends can invalidate caches after a sync changes something.
+func (c *AuditedHeadersController) Events() <-chan HeadersChanged {
+	return c.events
+}
+
+// Run syncs on every tick of interval until ctx is done or Stop is called.
+// Callers should invoke Run in its own goroutine.
+func (c *AuditedHeadersController) Run(ctx context.Context) {
+	defer close(c.doneCh)
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.stopCh:
+			return
+		case <-ticker.C:
+			c.sync(ctx)
+		}
+	}
+}
+
+// Stop halts the sync loop and waits for Run to return.
+func (c *AuditedHeadersController) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stopCh)
+	})
+	<-c.doneCh
+}
+
+// sync fetches the desired state from source and diffs it against the
+// config's current header map for c.direction, applying differences via
+// add/remove and emitting a HeadersChanged event if anything changed.
+func (c *AuditedHeadersController) sync(ctx context.Context) {
+	desired, err := c.source.Fetch(ctx)
+	if err != nil {
+		return
+	}
+
+	// Snapshot the current state under the minimal lock window possible;
+	// add/remove take their own lock, so none of the storage writes below
+	// happen while we're still holding this one.
+	c.config.RLock()
+	current := make(map[string]*auditedHeaderSettings, len(c.config.headersLocked(c.direction)))
+	for k, v := range c.config.headersLocked(c.direction) {
+		current[k] = v
+	}
+	c.config.RUnlock()
+
+	var changed HeadersChanged
+
+	for header, settings := range desired {
+		// add stores an empty Mode as passthrough, so compare against that
+		// form; otherwise such an entry would be re-added on every tick.
+		want := *settings
+		if want.Mode == "" {
+			want.Mode = headerModePassthrough
+		}
+
+		existing, ok := current[header]
+		var bucket *[]string
+		switch {
+		case !ok:
+			bucket = &changed.Added
+		case !reflect.DeepEqual(existing, &want):
+			bucket = &changed.Changed
+		default:
+			continue
+		}
+
+		opts := AddHeaderOptions{Mode: settings.Mode, IsPrefix: settings.IsPrefix}
+		if err := c.config.add(ctx, header, opts, c.direction); err != nil {
+			// Leave this header out of changed and retry it on the next
+			// tick; the barrier write didn't land, so the in-memory map
+			// (and thus current on the next sync) is still the old value.
+			continue
+		}
+		*bucket = append(*bucket, header)
+	}
+
+	for header := range current {
+		if _, ok := desired[header]; ok {
+			continue
+		}
+		if err := c.config.remove(ctx, header, c.direction); err != nil {
+			continue
+		}
+		changed.Removed = append(changed.Removed, header)
+	}
+
+	if changed.isEmpty() {
+		return
+	}
+
+	select {
+	case c.events <- changed:
+	default:
+	}
+}
+
+// fileHeaderConfigEntry is the on-disk JSON shape read by
+// FileHeaderConfigSource: a flat list of header entries.
+type fileHeaderConfigEntry struct {
+	Pattern  string `json:"pattern"`
+	Mode     string `json:"mode"`
+	IsPrefix bool   `json:"is_prefix"`
+}
+
+// FileHeaderConfigSource implements HeaderConfigSource by polling a JSON
+// file on disk, re-reading it only when its mtime has advanced since the
+// last Fetch.
+type FileHeaderConfigSource struct {
+	Path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	cached  map[string]*auditedHeaderSettings
+}
+
+// Fetch implements HeaderConfigSource.
+func (f *FileHeaderConfigSource) Fetch(ctx context.Context) (map[string]*auditedHeaderSettings, error) {
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat header config file: %v", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.cached != nil && !info.ModTime().After(f.modTime) {
+		return f.cached, nil
+	}
+
+	raw, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header config file: %v", err)
+	}
+
+	var entries []fileHeaderConfigEntry
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse header config file: %v", err)
+	}
+
+	data := make(map[string]*auditedHeaderSettings, len(entries))
+	for _, e := range entries {
+		data[e.Pattern] = &auditedHeaderSettings{
+			Pattern:  e.Pattern,
+			Mode:     e.Mode,
+			IsPrefix: e.IsPrefix,
+		}
+	}
+
+	f.modTime = info.ModTime()
+	f.cached = data
+
+	return data, nil
+}
+
+// StorageHeaderConfigSource implements HeaderConfigSource by reading a
+// logical.Storage path whose value decodes to the same map shape
+// AuditedHeadersConfig itself persists.
+type StorageHeaderConfigSource struct {
+	Storage logical.Storage
+	Path    string
+}
+
+// Fetch implements HeaderConfigSource.
+func (s *StorageHeaderConfigSource) Fetch(ctx context.Context) (map[string]*auditedHeaderSettings, error) {
+	entry, err := s.Storage.Get(ctx, s.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header config from storage: %v", err)
+	}
+	if entry == nil {
+		return map[string]*auditedHeaderSettings{}, nil
+	}
+
+	data := make(map[string]*auditedHeaderSettings)
+	if err := entry.DecodeJSON(&data); err != nil {
+		return nil, fmt.Errorf("failed to decode header config from storage: %v", err)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,146 @@
+package vault
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type fakeHeaderConfigSource struct {
+	fetch func(ctx context.Context) (map[string]*auditedHeaderSettings, error)
+}
+
+func (f *fakeHeaderConfigSource) Fetch(ctx context.Context) (map[string]*auditedHeaderSettings, error) {
+	return f.fetch(ctx)
+}
+
+func TestNewAuditedHeadersController_InvalidInterval(t *testing.T) {
+	conf := mockAuditedHeadersConfig(t)
+	source := &fakeHeaderConfigSource{
+		fetch: func(ctx context.Context) (map[string]*auditedHeaderSettings, error) {
+			return nil, nil
+		},
+	}
+
+	for _, interval := range []time.Duration{0, -time.Second} {
+		if _, err := NewAuditedHeadersController(conf, source, headerDirectionRequest, interval); err == nil {
+			t.Fatalf("Expected an error for interval %s", interval)
+		}
+	}
+}
+
+func TestAuditedHeadersController_Sync(t *testing.T) {
+	conf := mockAuditedHeadersConfig(t)
+
+	if err := conf.add(context.Background(), "X-Keep", AddHeaderOptions{Mode: headerModePassthrough}, headerDirectionRequest); err != nil {
+		t.Fatalf("Error seeding config: %s", err)
+	}
+	if err := conf.add(context.Background(), "X-Stale", AddHeaderOptions{Mode: headerModePassthrough}, headerDirectionRequest); err != nil {
+		t.Fatalf("Error seeding config: %s", err)
+	}
+
+	desired := map[string]*auditedHeaderSettings{
+		"X-Keep": &auditedHeaderSettings{Pattern: "X-Keep", Mode: headerModePassthrough},
+		"X-New":  &auditedHeaderSettings{Pattern: "X-New", Mode: headerModeHMAC},
+	}
+
+	source := &fakeHeaderConfigSource{
+		fetch: func(ctx context.Context) (map[string]*auditedHeaderSettings, error) {
+			return desired, nil
+		},
+	}
+
+	controller, err := NewAuditedHeadersController(conf, source, headerDirectionRequest, time.Hour)
+	if err != nil {
+		t.Fatalf("Error creating controller: %s", err)
+	}
+	controller.sync(context.Background())
+
+	if _, ok := conf.RequestHeaders["X-Stale"]; ok {
+		t.Fatal("Expected stale header to be removed")
+	}
+	if _, ok := conf.RequestHeaders["X-New"]; !ok {
+		t.Fatal("Expected new header to be added")
+	}
+	if settings, ok := conf.RequestHeaders["X-Keep"]; !ok || settings.Mode != headerModePassthrough {
+		t.Fatal("Expected unchanged header to remain untouched")
+	}
+
+	select {
+	case changed := <-controller.Events():
+		if !reflect.DeepEqual(changed.Added, []string{"X-New"}) {
+			t.Fatalf("Expected Added to be [X-New], got %#v", changed.Added)
+		}
+		if !reflect.DeepEqual(changed.Removed, []string{"X-Stale"}) {
+			t.Fatalf("Expected Removed to be [X-Stale], got %#v", changed.Removed)
+		}
+	default:
+		t.Fatal("Expected a HeadersChanged event to be emitted")
+	}
+}
+
+func TestAuditedHeadersController_Sync_WriteFailure(t *testing.T) {
+	conf := &AuditedHeadersConfig{
+		RequestHeaders: make(map[string]*auditedHeaderSettings),
+		view:           &fakeBlockingBarrierView{},
+	}
+
+	desired := map[string]*auditedHeaderSettings{
+		"X-New": &auditedHeaderSettings{Pattern: "X-New", Mode: headerModeHMAC},
+	}
+
+	source := &fakeHeaderConfigSource{
+		fetch: func(ctx context.Context) (map[string]*auditedHeaderSettings, error) {
+			return desired, nil
+		},
+	}
+
+	controller, err := NewAuditedHeadersController(conf, source, headerDirectionRequest, time.Hour)
+	if err != nil {
+		t.Fatalf("Error creating controller: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	controller.sync(ctx)
+
+	if _, ok := conf.RequestHeaders["X-New"]; ok {
+		t.Fatal("Expected header to be left out of RequestHeaders after a failed write")
+	}
+
+	select {
+	case changed := <-controller.Events():
+		t.Fatalf("Expected no HeadersChanged event when the underlying write fails, got %#v", changed)
+	default:
+	}
+}
+
+func TestAuditedHeadersController_Sync_NoChange(t *testing.T) {
+	conf := mockAuditedHeadersConfig(t)
+	if err := conf.add(context.Background(), "X-Same", AddHeaderOptions{Mode: headerModePassthrough}, headerDirectionRequest); err != nil {
+		t.Fatalf("Error seeding config: %s", err)
+	}
+
+	desired := map[string]*auditedHeaderSettings{
+		"X-Same": &auditedHeaderSettings{Pattern: "X-Same", Mode: headerModePassthrough},
+	}
+
+	source := &fakeHeaderConfigSource{
+		fetch: func(ctx context.Context) (map[string]*auditedHeaderSettings, error) {
+			return desired, nil
+		},
+	}
+
+	controller, err := NewAuditedHeadersController(conf, source, headerDirectionRequest, time.Hour)
+	if err != nil {
+		t.Fatalf("Error creating controller: %s", err)
+	}
+	controller.sync(context.Background())
+
+	select {
+	case changed := <-controller.Events():
+		t.Fatalf("Expected no event to be emitted for an unchanged sync, got %#v", changed)
+	default:
+	}
+}
@@ -1,18 +1,22 @@
 package vault
 
 import (
+	"context"
+	"encoding/json"
 	"reflect"
 	"testing"
 
 	"github.com/hashicorp/vault/helper/salt"
+	"github.com/hashicorp/vault/logical"
 )
 
 func mockAuditedHeadersConfig(t *testing.T) *AuditedHeadersConfig {
 	_, barrier, _ := mockBarrier(t)
 	view := NewBarrierView(barrier, "foo/")
 	return &AuditedHeadersConfig{
-		Headers: make(map[string]*auditedHeaderSettings),
-		view:    view,
+		RequestHeaders:  make(map[string]*auditedHeaderSettings),
+		ResponseHeaders: make(map[string]*auditedHeaderSettings),
+		view:            view,
 	}
 }
 
@@ -24,21 +28,21 @@ func TestAuditedHeadersConfig_CRUD(t *testing.T) {
 }
 
 func testAuditedHeadersConfig_Add(t *testing.T, conf *AuditedHeadersConfig) {
-	err := conf.add("X-Test-Header", false)
+	err := conf.add(context.Background(), "X-Test-Header", AddHeaderOptions{}, headerDirectionRequest)
 	if err != nil {
 		t.Fatalf("Error when adding header to config: %s", err)
 	}
 
-	settings, ok := conf.Headers["X-Test-Header"]
+	settings, ok := conf.RequestHeaders["X-Test-Header"]
 	if !ok {
 		t.Fatal("Expected header to be found in config")
 	}
 
-	if settings.HMAC {
-		t.Fatal("Expected HMAC to be set to false, got true")
+	if settings.Mode != headerModePassthrough {
+		t.Fatalf("Expected Mode to default to passthrough, got %q", settings.Mode)
 	}
 
-	out, err := conf.view.Get(auditedHeadersEntry)
+	out, err := conf.view.Get(context.Background(), auditedRequestHeadersEntry)
 	if err != nil {
 		t.Fatalf("Could not retrieve headers entry from config: %s", err)
 	}
@@ -51,7 +55,8 @@ func testAuditedHeadersConfig_Add(t *testing.T, conf *AuditedHeadersConfig) {
 
 	expected := map[string]*auditedHeaderSettings{
 		"X-Test-Header": &auditedHeaderSettings{
-			HMAC: false,
+			Pattern: "X-Test-Header",
+			Mode:    headerModePassthrough,
 		},
 	}
 
@@ -59,21 +64,21 @@ func testAuditedHeadersConfig_Add(t *testing.T, conf *AuditedHeadersConfig) {
 		t.Fatalf("Expected config didn't match actual. Expected: %#v, Got: %#v", expected, headers)
 	}
 
-	err = conf.add("X-Vault-Header", true)
+	err = conf.add(context.Background(), "X-Vault-Header", AddHeaderOptions{Mode: headerModeHMAC}, headerDirectionRequest)
 	if err != nil {
 		t.Fatalf("Error when adding header to config: %s", err)
 	}
 
-	settings, ok = conf.Headers["X-Vault-Header"]
+	settings, ok = conf.RequestHeaders["X-Vault-Header"]
 	if !ok {
 		t.Fatal("Expected header to be found in config")
 	}
 
-	if !settings.HMAC {
-		t.Fatal("Expected HMAC to be set to true, got false")
+	if settings.Mode != headerModeHMAC {
+		t.Fatalf("Expected Mode to be set to hmac, got %q", settings.Mode)
 	}
 
-	out, err = conf.view.Get(auditedHeadersEntry)
+	out, err = conf.view.Get(context.Background(), auditedRequestHeadersEntry)
 	if err != nil {
 		t.Fatalf("Could not retrieve headers entry from config: %s", err)
 	}
@@ -85,7 +90,8 @@ func testAuditedHeadersConfig_Add(t *testing.T, conf *AuditedHeadersConfig) {
 	}
 
 	expected["X-Vault-Header"] = &auditedHeaderSettings{
-		HMAC: true,
+		Pattern: "X-Vault-Header",
+		Mode:    headerModeHMAC,
 	}
 
 	if !reflect.DeepEqual(headers, expected) {
@@ -95,17 +101,17 @@ func testAuditedHeadersConfig_Add(t *testing.T, conf *AuditedHeadersConfig) {
 }
 
 func testAuditedHeadersConfig_Remove(t *testing.T, conf *AuditedHeadersConfig) {
-	err := conf.remove("X-Test-Header")
+	err := conf.remove(context.Background(), "X-Test-Header", headerDirectionRequest)
 	if err != nil {
 		t.Fatalf("Error when adding header to config: %s", err)
 	}
 
-	_, ok := conf.Headers["X-Test-Header"]
+	_, ok := conf.RequestHeaders["X-Test-Header"]
 	if ok {
 		t.Fatal("Expected header to not be found in config")
 	}
 
-	out, err := conf.view.Get(auditedHeadersEntry)
+	out, err := conf.view.Get(context.Background(), auditedRequestHeadersEntry)
 	if err != nil {
 		t.Fatalf("Could not retrieve headers entry from config: %s", err)
 	}
@@ -118,7 +124,8 @@ func testAuditedHeadersConfig_Remove(t *testing.T, conf *AuditedHeadersConfig) {
 
 	expected := map[string]*auditedHeaderSettings{
 		"X-Vault-Header": &auditedHeaderSettings{
-			HMAC: true,
+			Pattern: "X-Vault-Header",
+			Mode:    headerModeHMAC,
 		},
 	}
 
@@ -126,17 +133,17 @@ func testAuditedHeadersConfig_Remove(t *testing.T, conf *AuditedHeadersConfig) {
 		t.Fatalf("Expected config didn't match actual. Expected: %#v, Got: %#v", expected, headers)
 	}
 
-	err = conf.remove("X-Vault-Header")
+	err = conf.remove(context.Background(), "X-Vault-Header", headerDirectionRequest)
 	if err != nil {
 		t.Fatalf("Error when adding header to config: %s", err)
 	}
 
-	_, ok = conf.Headers["X-Vault-Header"]
+	_, ok = conf.RequestHeaders["X-Vault-Header"]
 	if ok {
 		t.Fatal("Expected header to not be found in config")
 	}
 
-	out, err = conf.view.Get(auditedHeadersEntry)
+	out, err = conf.view.Get(context.Background(), auditedRequestHeadersEntry)
 	if err != nil {
 		t.Fatalf("Could not retrieve headers entry from config: %s", err)
 	}
@@ -157,9 +164,9 @@ func testAuditedHeadersConfig_Remove(t *testing.T, conf *AuditedHeadersConfig) {
 func TestAuditedHeadersConfig_ApplyConfig(t *testing.T) {
 	conf := mockAuditedHeadersConfig(t)
 
-	conf.Headers = map[string]*auditedHeaderSettings{
-		"X-Test-Header":  &auditedHeaderSettings{false},
-		"X-Vault-Header": &auditedHeaderSettings{true},
+	conf.RequestHeaders = map[string]*auditedHeaderSettings{
+		"X-Test-Header":  &auditedHeaderSettings{Pattern: "X-Test-Header", Mode: headerModePassthrough},
+		"X-Vault-Header": &auditedHeaderSettings{Pattern: "X-Vault-Header", Mode: headerModeHMAC},
 	}
 
 	reqHeaders := map[string][]string{
@@ -194,15 +201,307 @@ func TestAuditedHeadersConfig_ApplyConfig(t *testing.T) {
 
 }
 
+func TestAuditedHeadersConfig_ApplyConfig_Modes(t *testing.T) {
+	conf := mockAuditedHeadersConfig(t)
+
+	conf.RequestHeaders = map[string]*auditedHeaderSettings{
+		"X-Redact-Header":   &auditedHeaderSettings{Pattern: "X-Redact-Header", Mode: headerModeRedact},
+		"X-Truncate-Header": &auditedHeaderSettings{Pattern: "X-Truncate-Header", Mode: "truncate:4"},
+		"X-Mask-Header":     &auditedHeaderSettings{Pattern: "X-Mask-Header", Mode: "mask:keep4"},
+	}
+
+	reqHeaders := map[string][]string{
+		"X-Redact-Header":   []string{"super-secret"},
+		"X-Truncate-Header": []string{"Bearer abc123"},
+		"X-Mask-Header":     []string{"1234567890"},
+	}
+
+	hashFunc := func(s string) string { return "hashed" }
+
+	result := conf.ApplyConfig(reqHeaders, hashFunc)
+
+	expected := map[string][]string{
+		"X-Redact-Header":   []string{"<redacted>"},
+		"X-Truncate-Header": []string{"Bear..."},
+		"X-Mask-Header":     []string{"******7890"},
+	}
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected headers did not match actual: Expected %#v\n Got %#v\n", expected, result)
+	}
+}
+
+func TestAuditedHeadersSettings_UnmarshalJSON_LegacyHMAC(t *testing.T) {
+	cases := []struct {
+		json     string
+		expected string
+	}{
+		{`{"Pattern":"X-Test","HMAC":true}`, headerModeHMAC},
+		{`{"Pattern":"X-Test","HMAC":false}`, headerModePassthrough},
+		{`{"Pattern":"X-Test","Mode":"redact"}`, headerModeRedact},
+		{`{"Pattern":"X-Test"}`, headerModePassthrough},
+	}
+
+	for _, tc := range cases {
+		var settings auditedHeaderSettings
+		if err := json.Unmarshal([]byte(tc.json), &settings); err != nil {
+			t.Fatalf("Error decoding %s: %s", tc.json, err)
+		}
+		if settings.Mode != tc.expected {
+			t.Fatalf("Decoding %s: expected Mode %q, got %q", tc.json, tc.expected, settings.Mode)
+		}
+	}
+}
+
+func TestAuditedHeadersConfig_ApplyConfig_Prefix(t *testing.T) {
+	conf := mockAuditedHeadersConfig(t)
+
+	conf.RequestHeaders = map[string]*auditedHeaderSettings{
+		"X-Request-Id": &auditedHeaderSettings{Pattern: "X-Request-Id", Mode: headerModePassthrough},
+		"X-Request-":   &auditedHeaderSettings{Pattern: "X-Request-", Mode: headerModeHMAC, IsPrefix: true},
+		"X-Forwarded-": &auditedHeaderSettings{Pattern: "X-Forwarded-", Mode: headerModePassthrough, IsPrefix: true},
+	}
+	conf.rebuildPrefixesLocked(headerDirectionRequest)
+
+	reqHeaders := map[string][]string{
+		// Matches the exact entry, which must win over the overlapping
+		// "X-Request-" prefix entry.
+		"X-Request-Id": []string{"abc"},
+		// Only matches the "X-Request-" prefix.
+		"X-Request-Session": []string{"def"},
+		// Only matches the "X-Forwarded-" prefix.
+		"X-Forwarded-For": []string{"1.2.3.4"},
+		"Content-Type":    []string{"json"},
+	}
+
+	hashFunc := func(s string) string { return "hashed" }
+
+	result := conf.ApplyConfig(reqHeaders, hashFunc)
+
+	expected := map[string][]string{
+		"X-Request-Id":      []string{"abc"},
+		"X-Request-Session": []string{"hashed"},
+		"X-Forwarded-For":   []string{"1.2.3.4"},
+	}
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected headers did not match actual: Expected %#v\n Got %#v\n", expected, result)
+	}
+}
+
+func TestAuditedHeadersConfig_ApplyConfig_OverlappingPrefixes(t *testing.T) {
+	conf := mockAuditedHeadersConfig(t)
+
+	conf.RequestHeaders = map[string]*auditedHeaderSettings{
+		"X-":     &auditedHeaderSettings{Pattern: "X-", Mode: headerModeRedact, IsPrefix: true},
+		"X-Req-": &auditedHeaderSettings{Pattern: "X-Req-", Mode: headerModePassthrough, IsPrefix: true},
+	}
+	conf.rebuildPrefixesLocked(headerDirectionRequest)
+
+	reqHeaders := map[string][]string{
+		// Matches both prefixes; the longer, more specific "X-Req-" must
+		// win deterministically regardless of map iteration order.
+		"X-Req-Id": []string{"abc"},
+		// Only matches the shorter "X-" prefix.
+		"X-Other": []string{"def"},
+	}
+
+	hashFunc := func(s string) string { return "hashed" }
+
+	// Run several times since the bug this guards against is map iteration
+	// order, which Go randomizes on every range over a map, so each rebuild
+	// of the prefix list below can see a different order.
+	for i := 0; i < 20; i++ {
+		conf.rebuildPrefixesLocked(headerDirectionRequest)
+
+		result := conf.ApplyConfig(reqHeaders, hashFunc)
+
+		expected := map[string][]string{
+			"X-Req-Id": []string{"abc"},
+			"X-Other":  []string{redactedValue},
+		}
+
+		if !reflect.DeepEqual(result, expected) {
+			t.Fatalf("Expected headers did not match actual: Expected %#v\n Got %#v\n", expected, result)
+		}
+	}
+}
+
+func TestAuditedHeadersConfig_ResponseHeaders(t *testing.T) {
+	conf := mockAuditedHeadersConfig(t)
+
+	err := conf.add(context.Background(), "Set-Cookie", AddHeaderOptions{Mode: headerModeHMAC}, headerDirectionResponse)
+	if err != nil {
+		t.Fatalf("Error when adding response header to config: %s", err)
+	}
+
+	// The request-header namespace must be untouched.
+	if len(conf.RequestHeaders) != 0 {
+		t.Fatalf("Expected RequestHeaders to be empty, got %#v", conf.RequestHeaders)
+	}
+
+	out, err := conf.view.Get(context.Background(), auditedResponseHeadersEntry)
+	if err != nil {
+		t.Fatalf("Could not retrieve response headers entry from config: %s", err)
+	}
+
+	headers := make(map[string]*auditedHeaderSettings)
+	if err := out.DecodeJSON(&headers); err != nil {
+		t.Fatalf("Error decoding response header view: %s", err)
+	}
+
+	expected := map[string]*auditedHeaderSettings{
+		"Set-Cookie": &auditedHeaderSettings{Pattern: "Set-Cookie", Mode: headerModeHMAC},
+	}
+
+	if !reflect.DeepEqual(headers, expected) {
+		t.Fatalf("Expected config didn't match actual. Expected: %#v, Got: %#v", expected, headers)
+	}
+
+	respHeaders := map[string][]string{
+		"Set-Cookie":    []string{"session=abc"},
+		"X-Vault-Index": []string{"42"},
+	}
+
+	hashFunc := func(s string) string { return "hashed" }
+	result := conf.ApplyResponseConfig(respHeaders, hashFunc)
+
+	resultExpected := map[string][]string{
+		"Set-Cookie": []string{"hashed"},
+	}
+
+	if !reflect.DeepEqual(result, resultExpected) {
+		t.Fatalf("Expected response headers did not match actual: Expected %#v\n Got %#v\n", resultExpected, result)
+	}
+
+	if err := conf.remove(context.Background(), "Set-Cookie", headerDirectionResponse); err != nil {
+		t.Fatalf("Error when removing response header from config: %s", err)
+	}
+
+	if _, ok := conf.ResponseHeaders["Set-Cookie"]; ok {
+		t.Fatal("Expected response header to not be found in config")
+	}
+}
+
+func TestAuditedHeadersConfig_Migration(t *testing.T) {
+	_, barrier, _ := mockBarrier(t)
+	view := NewBarrierView(barrier, "foo/")
+
+	legacy := map[string]*auditedHeaderSettings{
+		"X-Test-Header": &auditedHeaderSettings{Pattern: "X-Test-Header", Mode: headerModeHMAC},
+	}
+	entry, err := logical.StorageEntryJSON(legacyAuditedHeadersEntry, legacy)
+	if err != nil {
+		t.Fatalf("Error encoding legacy config: %s", err)
+	}
+	if err := view.Put(context.Background(), entry); err != nil {
+		t.Fatalf("Error writing legacy config: %s", err)
+	}
+
+	conf, err := loadAuditedHeadersConfig(context.Background(), view)
+	if err != nil {
+		t.Fatalf("Error loading audited headers config: %s", err)
+	}
+
+	if !reflect.DeepEqual(conf.RequestHeaders, legacy) {
+		t.Fatalf("Expected legacy config to be migrated into RequestHeaders. Expected: %#v, Got: %#v", legacy, conf.RequestHeaders)
+	}
+
+	out, err := view.Get(context.Background(), auditedRequestHeadersEntry)
+	if err != nil {
+		t.Fatalf("Error reading migrated config: %s", err)
+	}
+	if out == nil {
+		t.Fatal("Expected migrated config to be written under the new request-headers key")
+	}
+
+	migrated := make(map[string]*auditedHeaderSettings)
+	if err := out.DecodeJSON(&migrated); err != nil {
+		t.Fatalf("Error decoding migrated config: %s", err)
+	}
+	if !reflect.DeepEqual(migrated, legacy) {
+		t.Fatalf("Expected migrated config to match legacy config. Expected: %#v, Got: %#v", legacy, migrated)
+	}
+}
+
+func TestAuditedHeadersConfig_Migration_SkippedWhenRequestEntryExistsEmpty(t *testing.T) {
+	_, barrier, _ := mockBarrier(t)
+	view := NewBarrierView(barrier, "foo/")
+
+	legacy := map[string]*auditedHeaderSettings{
+		"X-Test-Header": &auditedHeaderSettings{Pattern: "X-Test-Header", Mode: headerModeHMAC},
+	}
+	legacyEntry, err := logical.StorageEntryJSON(legacyAuditedHeadersEntry, legacy)
+	if err != nil {
+		t.Fatalf("Error encoding legacy config: %s", err)
+	}
+	if err := view.Put(context.Background(), legacyEntry); err != nil {
+		t.Fatalf("Error writing legacy config: %s", err)
+	}
+
+	// An operator has already migrated and deliberately removed every
+	// audited request header, so the new key exists with an empty map.
+	reqEntry, err := logical.StorageEntryJSON(auditedRequestHeadersEntry, map[string]*auditedHeaderSettings{})
+	if err != nil {
+		t.Fatalf("Error encoding request headers config: %s", err)
+	}
+	if err := view.Put(context.Background(), reqEntry); err != nil {
+		t.Fatalf("Error writing request headers config: %s", err)
+	}
+
+	conf, err := loadAuditedHeadersConfig(context.Background(), view)
+	if err != nil {
+		t.Fatalf("Error loading audited headers config: %s", err)
+	}
+
+	if len(conf.RequestHeaders) != 0 {
+		t.Fatalf("Expected the deliberately empty RequestHeaders to be left alone, got %#v", conf.RequestHeaders)
+	}
+}
+
+// fakeBlockingBarrierView blocks on Put/Get until its context is done, so
+// tests can assert that a cancelled context aborts the storage write before
+// it ever reaches the barrier.
+type fakeBlockingBarrierView struct{}
+
+func (f *fakeBlockingBarrierView) Put(ctx context.Context, entry *logical.StorageEntry) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (f *fakeBlockingBarrierView) Get(ctx context.Context, key string) (*logical.StorageEntry, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestAuditedHeadersConfig_Add_ContextCancellation(t *testing.T) {
+	conf := &AuditedHeadersConfig{
+		RequestHeaders: make(map[string]*auditedHeaderSettings),
+		view:           &fakeBlockingBarrierView{},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := conf.add(ctx, "X-Test-Header", AddHeaderOptions{}, headerDirectionRequest)
+	if err == nil {
+		t.Fatal("Expected add to fail when context is cancelled")
+	}
+
+	if len(conf.RequestHeaders) != 0 {
+		t.Fatalf("Expected RequestHeaders to be left untouched after a failed write, got %#v", conf.RequestHeaders)
+	}
+}
+
 func BenchmarkAuditedHeaderConfig_ApplyConfig(b *testing.B) {
 	conf := &AuditedHeadersConfig{
-		Headers: make(map[string]*auditedHeaderSettings),
-		view:    nil,
+		RequestHeaders: make(map[string]*auditedHeaderSettings),
+		view:           nil,
 	}
 
-	conf.Headers = map[string]*auditedHeaderSettings{
-		"X-Test-Header":  &auditedHeaderSettings{false},
-		"X-Vault-Header": &auditedHeaderSettings{true},
+	conf.RequestHeaders = map[string]*auditedHeaderSettings{
+		"X-Test-Header":  &auditedHeaderSettings{Pattern: "X-Test-Header", Mode: headerModePassthrough},
+		"X-Vault-Header": &auditedHeaderSettings{Pattern: "X-Vault-Header", Mode: headerModeHMAC},
 	}
 
 	reqHeaders := map[string][]string{